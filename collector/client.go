@@ -2,6 +2,8 @@ package collector
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -9,6 +11,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -18,23 +21,84 @@ import (
 
 /*CacheObjectClient holds information about squid manager */
 type CacheObjectClient struct {
-	hostname          string
-	port              int
-	basicAuthString   string
-	headers           map[string]string
-	withProxyProtocal bool
+	hostname             string
+	port                 int
+	basicAuthString      string
+	headers              map[string]string
+	withProxyProtocal    bool
+	proxyProtocolVersion ProxyProtocolVersion
+	proxySource          net.Addr
+	proxyDestination     net.Addr
+	proxyURL             *url.URL
+	tlsConfig            *tls.Config
 }
 
+/*ProxyProtocolVersion selects the PROXY protocol variant written ahead of the HTTP request, if any */
+type ProxyProtocolVersion int
+
+const (
+	// ProxyProtocolNone sends no PROXY protocol header.
+	ProxyProtocolNone ProxyProtocolVersion = iota
+	// ProxyProtocolV1 sends a human-readable PROXY protocol v1 header.
+	ProxyProtocolV1
+	// ProxyProtocolV2 sends a binary PROXY protocol v2 header with TLVs.
+	ProxyProtocolV2
+)
+
+// pp2TypeSquidExporter is a custom TLV, the first value of the 0xE0-0xEF range
+// the PROXY protocol spec reserves for application-specific data, that identifies the exporter's scrape
+// connections so Squid/HAProxy ACLs can tell them apart from real clients.
+const pp2TypeSquidExporter = 0xE0
+
 /*SquidClient provides functionality to fetch squid metrics */
 type SquidClient interface {
 	GetCounters() (types.Counters, error)
 	GetServiceTimes() (types.Counters, error)
+	GetCountersContext(ctx context.Context) (types.Counters, error)
+	GetServiceTimesContext(ctx context.Context) (types.Counters, error)
 }
 
 const (
 	requestProtocol = "GET cache_object://localhost/%s HTTP/1.0"
 )
 
+/*ClientOption configures optional behaviour on a CacheObjectClient */
+type ClientOption func(*CacheObjectClient)
+
+/*WithProxyURL tunnels the connection to the cache manager through an HTTP CONNECT proxy, overriding the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment defaults */
+func WithProxyURL(proxyURL *url.URL) ClientOption {
+	return func(c *CacheObjectClient) {
+		c.proxyURL = proxyURL
+	}
+}
+
+/*WithTLS enables TLS on the connection to the cache manager, using cfg (CA bundle, client cert/key, InsecureSkipVerify, ServerName) for the handshake. A nil cfg turns on TLS with the zero value tls.Config */
+func WithTLS(cfg *tls.Config) ClientOption {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+
+	return func(c *CacheObjectClient) {
+		c.tlsConfig = cfg
+	}
+}
+
+/*WithProxyProtocolVersion selects the PROXY protocol variant (v1 or v2) written ahead of the HTTP request, enabling the header if version isn't ProxyProtocolNone */
+func WithProxyProtocolVersion(version ProxyProtocolVersion) ClientOption {
+	return func(c *CacheObjectClient) {
+		c.proxyProtocolVersion = version
+		c.withProxyProtocal = version != ProxyProtocolNone
+	}
+}
+
+/*WithProxyAddrs overrides the source/destination addresses reported in the PROXY protocol header; IPv4 vs IPv6 (and TCPv4 vs TCPv6) is inferred from the address family */
+func WithProxyAddrs(source, destination net.Addr) ClientOption {
+	return func(c *CacheObjectClient) {
+		c.proxySource = source
+		c.proxyDestination = destination
+	}
+}
+
 func buildBasicAuthString(login string, password string) string {
 	if len(login) == 0 {
 		return ""
@@ -44,119 +108,185 @@ func buildBasicAuthString(login string, password string) string {
 }
 
 /*NewCacheObjectClient initializes a new cache client */
-func NewCacheObjectClient(hostname string, port int, login string, password string, withProxyProtocal bool) *CacheObjectClient {
-	return &CacheObjectClient{
-		hostname,
-		port,
-		buildBasicAuthString(login, password),
-		map[string]string{},
-		withProxyProtocal,
+func NewCacheObjectClient(hostname string, port int, login string, password string, withProxyProtocal bool, opts ...ClientOption) *CacheObjectClient {
+	proxyProtocolVersion := ProxyProtocolNone
+	if withProxyProtocal {
+		proxyProtocolVersion = ProxyProtocolV1
 	}
-}
 
-func readFromSquid(hostname string, port int, basicAuthString string, endpoint string, withProxyProtocal bool) (*bufio.Reader, error) {
-	conn, err := connect(hostname, port)
+	c := &CacheObjectClient{
+		hostname:             hostname,
+		port:                 port,
+		basicAuthString:      buildBasicAuthString(login, password),
+		headers:              map[string]string{},
+		withProxyProtocal:    withProxyProtocal,
+		proxyProtocolVersion: proxyProtocolVersion,
+	}
 
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func (c *CacheObjectClient) readFromSquid(endpoint string) (*bufio.Reader, error) {
+	reader, done, err := c.readFromSquidContext(context.Background(), endpoint)
 	if err != nil {
 		return nil, err
 	}
+	done()
 
-	if withProxyProtocal {
-		// set proxy proto header (version 1)
-		// from: localhost:80
-		// to: localhost: <port>
-		header := &proxyproto.Header{
-			Version:           1,
-			Command:           proxyproto.PROXY,
-			TransportProtocol: proxyproto.TCPv4,
-			SourceAddr: &net.TCPAddr{
-				IP:   net.ParseIP("127.0.0.1"),
-				Port: 80,
-			},
-
-			DestinationAddr: &net.TCPAddr{
-				IP:   net.ParseIP("127.0.0.1"),
-				Port: port,
-			},
+	return reader, nil
+}
+
+// readFromSquidContext returns a reader plus a done func the caller must
+// invoke once it has finished reading. On an error return done has already
+// been called and must not be called again. done stops the ctx-cancellation
+// watcher below; without it the watcher would block forever on a ctx (e.g.
+// context.Background()) that's never cancelled.
+func (c *CacheObjectClient) readFromSquidContext(ctx context.Context, endpoint string) (reader *bufio.Reader, done func(), err error) {
+	conn, err := c.connectContext(ctx)
+	if err != nil {
+		return nil, func() {}, err
+	}
+
+	doneCh := make(chan struct{})
+	done = func() { close(doneCh) }
+
+	// Abort the in-flight read as soon as the scrape's context is done,
+	// since neither net.Conn nor bufio.Reader take a context themselves.
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-doneCh:
 		}
-		// After the connection was created write the proxy headers first
-		_, err = header.WriteTo(conn)
+	}()
 
+	defer func() {
 		if err != nil {
-			return nil, err
+			done()
+		}
+	}()
+
+	if c.withProxyProtocal {
+		header, herr := c.proxyProtocolHeader()
+		if herr != nil {
+			err = herr
+			return nil, done, err
+		}
+
+		// After the connection was created write the proxy headers first
+		if _, werr := header.WriteTo(conn); werr != nil {
+			err = werr
+			return nil, done, err
 		}
 	}
 
-	r, err := get(conn, endpoint, basicAuthString)
+	if c.tlsConfig != nil {
+		// Wrapped after the proxy protocol header so PROXY v1/v2 + TLS
+		// termination setups (e.g. stunnel/HAProxy in front of Squid) work;
+		// the handshake itself happens lazily on the first read/write below.
+		conn = tls.Client(conn, c.tlsConfig)
+	}
 
-	if err != nil {
-		return nil, err
+	r, gerr := get(conn, endpoint, c.basicAuthString)
+	if gerr != nil {
+		err = gerr
+		return nil, done, err
 	}
 
 	if r.StatusCode != 200 {
-		return nil, fmt.Errorf("Non success code %d while fetching metrics", r.StatusCode)
+		err = fmt.Errorf("Non success code %d while fetching metrics", r.StatusCode)
+		return nil, done, err
 	}
 
-	return bufio.NewReader(r.Body), err
+	return bufio.NewReader(r.Body), done, nil
 }
 
-func readLines(reader *bufio.Reader, lines chan<- string) {
+// readLines streams lines from reader until EOF or a read error, then closes
+// lines and reports the outcome on errc (nil on a clean EOF). A non-EOF error
+// here also covers conn being closed out from under reader by the
+// ctx-cancellation watcher in readFromSquidContext, so a mid-stream timeout
+// is reported to the caller instead of returning a truncated result as success.
+func readLines(reader *bufio.Reader, lines chan<- string, errc chan<- error) {
 	for {
 		line, err := reader.ReadString('\n')
 
 		if err == io.EOF {
-			break
+			close(lines)
+			errc <- nil
+			return
 		}
 		if err != nil {
-			log.Printf("error reading from the bufio.Reader: %v", err)
-			break
+			close(lines)
+			errc <- err
+			return
 		}
 
 		lines <- line
 	}
-	close(lines)
 }
 
 /*GetCounters fetches counters from squid cache manager */
 func (c *CacheObjectClient) GetCounters() (types.Counters, error) {
+	return c.GetCountersContext(context.Background())
+}
+
+/*GetCountersContext fetches counters from squid cache manager, aborting the scrape once ctx is done */
+func (c *CacheObjectClient) GetCountersContext(ctx context.Context) (types.Counters, error) {
 	var counters types.Counters
 
-	reader, err := readFromSquid(c.hostname, c.port, c.basicAuthString, "counters", c.withProxyProtocal)
+	reader, done, err := c.readFromSquidContext(ctx, "counters")
 	if err != nil {
 		return nil, fmt.Errorf("error getting counters: %v", err)
 	}
+	defer done()
 
 	lines := make(chan string)
-	go readLines(reader, lines)
+	errc := make(chan error, 1)
+	go readLines(reader, lines, errc)
 
 	for line := range lines {
-		c, err := decodeCounterStrings(line)
-		if err != nil {
-			log.Println(err)
+		c, decodeErr := decodeCounterStrings(line)
+		if decodeErr != nil {
+			log.Println(decodeErr)
 		} else {
 			counters = append(counters, c)
 		}
 	}
 
-	return counters, err
+	if err := <-errc; err != nil {
+		return nil, fmt.Errorf("error reading counters: %v", err)
+	}
+
+	return counters, nil
 }
 
 /*GetServiceTimes fetches service times from squid cache manager */
 func (c *CacheObjectClient) GetServiceTimes() (types.Counters, error) {
+	return c.GetServiceTimesContext(context.Background())
+}
+
+/*GetServiceTimesContext fetches service times from squid cache manager, aborting the scrape once ctx is done */
+func (c *CacheObjectClient) GetServiceTimesContext(ctx context.Context) (types.Counters, error) {
 	var serviceTimes types.Counters
 
-	reader, err := readFromSquid(c.hostname, c.port, c.basicAuthString, "service_times", c.withProxyProtocal)
+	reader, done, err := c.readFromSquidContext(ctx, "service_times")
 	if err != nil {
 		return nil, fmt.Errorf("error getting service times: %v", err)
 	}
+	defer done()
 
 	lines := make(chan string)
-	go readLines(reader, lines)
+	errc := make(chan error, 1)
+	go readLines(reader, lines, errc)
 
 	for line := range lines {
-		s, err := decodeServiceTimeStrings(line)
-		if err != nil {
-			log.Println(err)
+		s, decodeErr := decodeServiceTimeStrings(line)
+		if decodeErr != nil {
+			log.Println(decodeErr)
 		} else {
 			if s.Key != "" {
 				serviceTimes = append(serviceTimes, s)
@@ -164,11 +294,100 @@ func (c *CacheObjectClient) GetServiceTimes() (types.Counters, error) {
 		}
 	}
 
-	return serviceTimes, err
+	if err := <-errc; err != nil {
+		return nil, fmt.Errorf("error reading service times: %v", err)
+	}
+
+	return serviceTimes, nil
+}
+
+func (c *CacheObjectClient) connectContext(ctx context.Context) (net.Conn, error) {
+	addr := fmt.Sprintf("%s:%d", c.hostname, c.port)
+
+	proxyURL, err := c.resolveProxyURL(addr)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving proxy for %s: %v", addr, err)
+	}
+
+	var conn net.Conn
+	if proxyURL == nil {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialViaProxyConnectContext(ctx, proxyURL, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// resolveProxyURL returns the proxy to tunnel through, preferring an explicit
+// WithProxyURL over the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+// A nil return means the connection should be dialed directly.
+func (c *CacheObjectClient) resolveProxyURL(addr string) (*url.URL, error) {
+	if c.proxyURL != nil {
+		return c.proxyURL, nil
+	}
+
+	scheme := "http"
+	if c.tlsConfig != nil {
+		// http.ProxyFromEnvironment only consults HTTPS_PROXY for an
+		// "https" scheme, so a plain "http" here would silently ignore it.
+		scheme = "https"
+	}
+
+	return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+}
+
+// proxyProtocolHeader builds the PROXY protocol header (v1 or v2) advertised
+// ahead of the HTTP request, from: 127.0.0.1:80 to: 127.0.0.1:<port> unless
+// overridden with WithProxyAddrs.
+func (c *CacheObjectClient) proxyProtocolHeader() (*proxyproto.Header, error) {
+	source := c.proxySource
+	if source == nil {
+		source = &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}
+	}
+
+	destination := c.proxyDestination
+	if destination == nil {
+		destination = &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: c.port}
+	}
+
+	header := &proxyproto.Header{
+		Version:           byte(c.proxyProtocolVersion),
+		Command:           proxyproto.PROXY,
+		TransportProtocol: transportProtocolOf(destination),
+		SourceAddr:        source,
+		DestinationAddr:   destination,
+	}
+
+	if c.proxyProtocolVersion == ProxyProtocolV2 {
+		if err := header.SetTLVs([]proxyproto.TLV{
+			{Type: proxyproto.PP2_TYPE_AUTHORITY, Value: []byte(c.hostname)},
+			{Type: pp2TypeSquidExporter, Value: []byte("squid-exporter")},
+		}); err != nil {
+			return nil, fmt.Errorf("error setting proxy protocol TLVs: %v", err)
+		}
+	}
+
+	return header, nil
 }
 
-func connect(hostname string, port int) (net.Conn, error) {
-	return net.Dial("tcp", fmt.Sprintf("%s:%d", hostname, port))
+// transportProtocolOf infers TCPv4 vs TCPv6 from the address family of addr.
+func transportProtocolOf(addr net.Addr) proxyproto.AddressFamilyAndProtocol {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && tcpAddr.IP.To4() == nil {
+		return proxyproto.TCPv6
+	}
+
+	return proxyproto.TCPv4
 }
 
 func get(conn net.Conn, path string, basicAuthString string) (*http.Response, error) {
@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestGetCountersContextAbortsOnHungServer ensures a scrape against a Squid
+// box that accepts the connection but never responds is aborted once the
+// context deadline passes, instead of blocking forever waiting for the
+// response.
+func TestGetCountersContextAbortsOnHungServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 1024)
+		conn.Read(buf) // drain the request, then go silent
+
+		time.Sleep(time.Second)
+	}()
+
+	host, portStr, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := NewCacheObjectClient(host, port, "", "", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.GetCountersContext(ctx); err == nil {
+		t.Fatal("expected an error from a hung cache manager, got nil")
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the scrape to abort near the context deadline, took %s", elapsed)
+	}
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestReadFromSquidContextWritesProxyHeaderBeforeTLSHandshake guards the
+// ordering PROXY v1/v2 + TLS termination setups (e.g. stunnel/HAProxy in
+// front of Squid) depend on: the PROXY protocol header must land on the raw
+// TCP conn before anything TLS-shaped, since the handshake is lazy and only
+// starts on the wrapped conn's first Read/Write.
+func TestReadFromSquidContextWritesProxyHeaderBeforeTLSHandshake(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	firstByteAfterHeader := make(chan byte, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		if _, err := br.ReadString('\n'); err != nil {
+			return
+		}
+
+		b, err := br.Peek(1)
+		if err != nil {
+			return
+		}
+		firstByteAfterHeader <- b[0]
+	}()
+
+	host, portStr, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := NewCacheObjectClient(host, port, "", "", true, WithTLS(&tls.Config{InsecureSkipVerify: true}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// The server below doesn't speak TLS, so the handshake triggered by this
+	// call fails; that's expected, we only care about what was written and
+	// in what order.
+	c.readFromSquidContext(ctx, "counters")
+
+	select {
+	case b := <-firstByteAfterHeader:
+		const tlsHandshakeRecordType = 0x16
+		if b != tlsHandshakeRecordType {
+			t.Errorf("expected a TLS handshake record (0x16) right after the PROXY header, got %#x", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for bytes after the PROXY header")
+	}
+}
@@ -0,0 +1,28 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/boynux/squid-exporter/types"
+)
+
+const (
+	counterMetricName     = "squid_counter"
+	serviceTimeMetricName = "squid_service_time"
+)
+
+/*WriteMetrics renders counters and service times in Prometheus text exposition format, labeled with squid_instance and key. Both the single-target /metrics endpoint and MultiClient's /probe endpoint render through this one function so they can never disagree on metric names or labels. */
+func WriteMetrics(w io.Writer, instance string, counters types.Counters, serviceTimes types.Counters) {
+	fmt.Fprintf(w, "# HELP %s Squid cache manager counters, from cache_object://.../counters\n", counterMetricName)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", counterMetricName)
+	for _, c := range counters {
+		fmt.Fprintf(w, "%s{squid_instance=%q,key=%q} %v\n", counterMetricName, instance, c.Key, c.Value)
+	}
+
+	fmt.Fprintf(w, "# HELP %s Squid cache manager service times, from cache_object://.../service_times\n", serviceTimeMetricName)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", serviceTimeMetricName)
+	for _, s := range serviceTimes {
+		fmt.Fprintf(w, "%s{squid_instance=%q,key=%q} %v\n", serviceTimeMetricName, instance, s.Key, s.Value)
+	}
+}
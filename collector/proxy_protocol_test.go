@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	proxyproto "github.com/pires/go-proxyproto"
+)
+
+func TestProxyProtocolHeaderV1(t *testing.T) {
+	c := NewCacheObjectClient("localhost", 3128, "", "", true)
+
+	header, err := c.proxyProtocolHeader()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed := assertHeaderRoundTrips(t, header)
+
+	if parsed.Version != 1 {
+		t.Errorf("expected version 1, got %d", parsed.Version)
+	}
+}
+
+func TestProxyProtocolHeaderV2(t *testing.T) {
+	c := NewCacheObjectClient("localhost", 3128, "", "", false, WithProxyProtocolVersion(ProxyProtocolV2))
+
+	header, err := c.proxyProtocolHeader()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed := assertHeaderRoundTrips(t, header)
+
+	if parsed.Version != 2 {
+		t.Errorf("expected version 2, got %d", parsed.Version)
+	}
+
+	tlvs, err := parsed.TLVs()
+	if err != nil {
+		t.Fatalf("unexpected error reading TLVs: %v", err)
+	}
+
+	if len(tlvs) != 2 {
+		t.Fatalf("expected 2 TLVs, got %d", len(tlvs))
+	}
+}
+
+// assertHeaderRoundTrips writes header down one end of a pipe and parses it
+// back with proxyproto.Read on the other end, the way a fake Squid listener
+// would before falling through to its normal HTTP handling.
+func assertHeaderRoundTrips(t *testing.T, header *proxyproto.Header) *proxyproto.Header {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		header.WriteTo(client)
+	}()
+
+	parsed, err := proxyproto.Read(bufio.NewReader(server))
+	if err != nil {
+		t.Fatalf("error parsing proxy protocol header: %v", err)
+	}
+
+	return parsed
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+)
+
+// fakeConnectProxy accepts a single connection, reads the CONNECT request,
+// and writes back the given raw response (plus any trailingBytes immediately
+// after it, to exercise the buffered-bytes guard in sendProxyConnect).
+func fakeConnectProxy(t *testing.T, response string, trailingBytes []byte) net.Listener {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+
+		// One Write, so the trailing bytes arrive together with the response
+		// and are already buffered when ReadResponse returns.
+		conn.Write(append([]byte(response), trailingBytes...))
+	}()
+
+	return ln
+}
+
+func TestDialViaProxyConnectContextSucceeds(t *testing.T) {
+	ln := fakeConnectProxy(t, "HTTP/1.1 200 Connection established\r\n\r\n", nil)
+	defer ln.Close()
+
+	proxyURL, err := url.Parse(fmt.Sprintf("http://%s", ln.Addr().String()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := dialViaProxyConnectContext(context.Background(), proxyURL, "squid.example.com:3128")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialViaProxyConnectContextRejectsNon2xx(t *testing.T) {
+	ln := fakeConnectProxy(t, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n", nil)
+	defer ln.Close()
+
+	proxyURL, err := url.Parse(fmt.Sprintf("http://%s", ln.Addr().String()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := dialViaProxyConnectContext(context.Background(), proxyURL, "squid.example.com:3128"); err == nil {
+		t.Fatal("expected an error for a non-2xx CONNECT response, got nil")
+	}
+}
+
+func TestDialViaProxyConnectContextRejectsBufferedBytes(t *testing.T) {
+	ln := fakeConnectProxy(t, "HTTP/1.1 200 Connection established\r\n\r\n", []byte("unexpected tunneled bytes"))
+	defer ln.Close()
+
+	proxyURL, err := url.Parse(fmt.Sprintf("http://%s", ln.Addr().String()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := dialViaProxyConnectContext(context.Background(), proxyURL, "squid.example.com:3128"); err == nil {
+		t.Fatal("expected an error when the proxy buffers bytes past the CONNECT response, got nil")
+	}
+}
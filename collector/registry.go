@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/boynux/squid-exporter/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// errUnknownTarget is wrapped into Probe's error when name isn't registered,
+// so ProbeHandler can tell "no such target" (404) apart from a registered
+// target that failed to scrape (502).
+var errUnknownTarget = errors.New("unknown target")
+
+/*MultiClient is a registry of named Squid targets that lets a single exporter process probe a whole fleet, similar to blackbox_exporter's multi-target pattern */
+type MultiClient struct {
+	mu      sync.RWMutex
+	targets map[string]*CacheObjectClient
+}
+
+/*NewMultiClient initializes an empty registry of Squid targets */
+func NewMultiClient() *MultiClient {
+	return &MultiClient{
+		targets: map[string]*CacheObjectClient{},
+	}
+}
+
+/*AddTarget registers a named Squid target, replacing any existing target under the same name */
+func (m *MultiClient) AddTarget(name string, client *CacheObjectClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.targets[name] = client
+}
+
+/*Probe fetches counters and service times for the named target, running both RPCs concurrently */
+func (m *MultiClient) Probe(ctx context.Context, name string) (counters types.Counters, serviceTimes types.Counters, err error) {
+	m.mu.RLock()
+	client, ok := m.targets[name]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", errUnknownTarget, name)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		var err error
+		counters, err = client.GetCountersContext(ctx)
+		return err
+	})
+
+	g.Go(func() error {
+		var err error
+		serviceTimes, err = client.GetServiceTimesContext(ctx)
+		return err
+	})
+
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	return counters, serviceTimes, nil
+}
+
+/*ProbeHandler serves /probe?target=<name>, returning the requested Squid instance's metrics labeled with squid_instance. The existing single-target /metrics endpoint keeps working unchanged alongside it. */
+func (m *MultiClient) ProbeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("target")
+	if name == "" {
+		http.Error(w, "target parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	counters, serviceTimes, err := m.Probe(r.Context(), name)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errUnknownTarget) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w, name, counters, serviceTimes)
+}
@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMultiClientProbeUnknownTarget(t *testing.T) {
+	m := NewMultiClient()
+
+	if _, _, err := m.Probe(context.Background(), "missing"); !errors.Is(err, errUnknownTarget) {
+		t.Fatalf("expected errUnknownTarget for an unregistered target, got %v", err)
+	}
+}
+
+func TestMultiClientProbeHandlerStatusCodes(t *testing.T) {
+	m := NewMultiClient()
+	m.AddTarget("squid-a", NewCacheObjectClient("127.0.0.1", 1, "", "", false))
+
+	req := httptest.NewRequest(http.MethodGet, "/probe?target=missing", nil)
+	rec := httptest.NewRecorder()
+	m.ProbeHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown target, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/probe?target=squid-a", nil)
+	rec = httptest.NewRecorder()
+	m.ProbeHandler(rec, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected %d for a registered but unreachable target, got %d", http.StatusBadGateway, rec.Code)
+	}
+}
+
+func TestMultiClientProbeSurfacesRPCError(t *testing.T) {
+	m := NewMultiClient()
+
+	c := NewCacheObjectClient("127.0.0.1", 1, "", "", false)
+	m.AddTarget("squid-a", c)
+
+	// The registered target isn't reachable, so both RPCs fail to dial; the
+	// error returned by errgroup must reach Probe's caller rather than an
+	// empty scrape being reported as success.
+	if _, _, err := m.Probe(context.Background(), "squid-a"); err == nil {
+		t.Fatal("expected an error probing an unreachable target, got nil")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/boynux/squid-exporter/collector"
+)
+
+func main() {
+	var (
+		hostname          = flag.String("squid-hostname", "localhost", "Squid cache manager hostname")
+		port              = flag.Int("squid-port", 3128, "Squid cache manager port")
+		login             = flag.String("squid-login", "", "Squid cache manager login")
+		password          = flag.String("squid-password", "", "Squid cache manager password")
+		withProxyProtocal = flag.Bool("proxy-protocol", false, "Send a PROXY protocol header ahead of the cache manager request (version set by -proxy-protocol-version)")
+		proxyProtocolVer  = flag.String("proxy-protocol-version", "v1", "PROXY protocol version to send when -proxy-protocol is set: v1 or v2")
+		proxyURLFlag      = flag.String("proxy-url", "", "Explicit HTTP CONNECT proxy URL for reaching the cache manager, overriding HTTP_PROXY/HTTPS_PROXY/NO_PROXY")
+		useTLS            = flag.Bool("tls", false, "Use TLS when connecting to the cache manager")
+		tlsCA             = flag.String("tls-ca", "", "PEM CA bundle used to verify the cache manager's certificate")
+		tlsCert           = flag.String("tls-cert", "", "PEM client certificate for mutual TLS")
+		tlsKey            = flag.String("tls-key", "", "PEM client key for mutual TLS")
+		tlsServerName     = flag.String("tls-server-name", "", "Server name used for TLS SNI and certificate verification")
+		tlsInsecure       = flag.Bool("tls-insecure", false, "Skip verifying the cache manager's TLS certificate")
+		targetsFlag       = flag.String("targets", "", "Additional comma-separated name=host:port Squid targets served via /probe?target=<name>, sharing the -squid-login/-password/-tls*/-proxy* settings with the primary instance")
+		listenAddress     = flag.String("web.listen-address", ":9301", "Address to listen on for the exporter's HTTP endpoints")
+	)
+
+	flag.Parse()
+
+	opts, err := clientOptionsFromFlags(*proxyURLFlag, *useTLS, *tlsCA, *tlsCert, *tlsKey, *tlsServerName, *tlsInsecure, *withProxyProtocal, *proxyProtocolVer)
+	if err != nil {
+		log.Fatalf("error configuring client: %v", err)
+	}
+
+	client := collector.NewCacheObjectClient(*hostname, *port, *login, *password, *withProxyProtocal, opts...)
+
+	http.HandleFunc("/metrics", metricsHandler(client, *hostname))
+
+	targets, err := parseTargetsFlag(*targetsFlag)
+	if err != nil {
+		log.Fatalf("error configuring targets: %v", err)
+	}
+
+	if len(targets) > 0 {
+		multiClient := collector.NewMultiClient()
+		for _, target := range targets {
+			multiClient.AddTarget(target.name, collector.NewCacheObjectClient(target.hostname, target.port, *login, *password, *withProxyProtocal, opts...))
+		}
+		http.HandleFunc("/probe", multiClient.ProbeHandler)
+	}
+
+	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+}
+
+// namedTarget is one entry of the -targets flag: a Squid instance reachable
+// via /probe?target=name alongside the primary -squid-hostname instance.
+type namedTarget struct {
+	name     string
+	hostname string
+	port     int
+}
+
+// parseTargetsFlag parses a comma-separated list of name=host:port entries.
+func parseTargetsFlag(s string) ([]namedTarget, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var targets []namedTarget
+	for _, entry := range strings.Split(s, ",") {
+		nameAndAddr := strings.SplitN(entry, "=", 2)
+		if len(nameAndAddr) != 2 || nameAndAddr[0] == "" {
+			return nil, fmt.Errorf("invalid -targets entry %q: want name=host:port", entry)
+		}
+
+		host, portStr, err := net.SplitHostPort(nameAndAddr[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid -targets entry %q: %v", entry, err)
+		}
+
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -targets entry %q: invalid port: %v", entry, err)
+		}
+
+		targets = append(targets, namedTarget{name: nameAndAddr[0], hostname: host, port: port})
+	}
+
+	return targets, nil
+}
+
+// clientOptionsFromFlags turns the parsed flags into collector.ClientOptions.
+func clientOptionsFromFlags(proxyURL string, useTLS bool, tlsCA, tlsCert, tlsKey, tlsServerName string, tlsInsecure bool, withProxyProtocal bool, proxyProtocolVersion string) ([]collector.ClientOption, error) {
+	var opts []collector.ClientOption
+
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -proxy-url: %v", err)
+		}
+		opts = append(opts, collector.WithProxyURL(u))
+	}
+
+	if useTLS {
+		cfg, err := tlsConfigFromFlags(tlsCA, tlsCert, tlsKey, tlsServerName, tlsInsecure)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, collector.WithTLS(cfg))
+	}
+
+	if withProxyProtocal {
+		version, err := proxyProtocolVersionFromFlag(proxyProtocolVersion)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, collector.WithProxyProtocolVersion(version))
+	}
+
+	return opts, nil
+}
+
+func proxyProtocolVersionFromFlag(version string) (collector.ProxyProtocolVersion, error) {
+	switch version {
+	case "v1":
+		return collector.ProxyProtocolV1, nil
+	case "v2":
+		return collector.ProxyProtocolV2, nil
+	default:
+		return collector.ProxyProtocolNone, fmt.Errorf("invalid -proxy-protocol-version %q: must be v1 or v2", version)
+	}
+}
+
+func tlsConfigFromFlags(tlsCA, tlsCert, tlsKey, tlsServerName string, tlsInsecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         tlsServerName,
+		InsecureSkipVerify: tlsInsecure,
+	}
+
+	if tlsCA != "" {
+		caCert, err := ioutil.ReadFile(tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading -tls-ca: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in -tls-ca %s", tlsCA)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if tlsCert != "" || tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading -tls-cert/-tls-key: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func metricsHandler(client collector.SquidClient, instance string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		counters, err := client.GetCountersContext(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		serviceTimes, err := client.GetServiceTimesContext(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		collector.WriteMetrics(w, instance, counters, serviceTimes)
+	}
+}